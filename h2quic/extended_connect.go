@@ -0,0 +1,57 @@
+package h2quic
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http2"
+)
+
+// extendedConnectProtocolSetting is the SETTINGS_ENABLE_CONNECT_PROTOCOL
+// identifier defined by RFC 8441 Section 3.
+const extendedConnectProtocolSetting http2.SettingID = 0x8
+
+// initialSettings returns the SETTINGS this server advertises to every
+// session it accepts. SETTINGS_ENABLE_CONNECT_PROTOCOL=1 tells the peer it
+// may use Extended CONNECT to bootstrap protocols, such as WebSockets, on
+// top of an h2quic stream. No code in this package writes these settings to
+// a session's header stream.
+func (s *Server) initialSettings() []http2.Setting {
+	return []http2.Setting{
+		{ID: extendedConnectProtocolSetting, Val: 1},
+	}
+}
+
+// handlePeerSetting applies an HTTP/2 SETTINGS value sent by the peer to
+// settings. SETTINGS_ENABLE_CONNECT_PROTOCOL isn't handled here: per RFC
+// 8441 Section 3, it's the server that advertises it to tell the peer
+// Extended CONNECT is available, not the other way around - a real client
+// never sends it back, so settings.enableExtendedConnect instead reflects
+// whether this server advertised it in the first place (see
+// newSessionSettings).
+func (s *Server) handlePeerSetting(settings *sessionSettings, setting http2.Setting) {
+	switch setting.ID {
+	case http2.SettingEnablePush:
+		settings.enablePush = setting.Val != 0
+	}
+}
+
+// newExtendedConnectRequest builds the *http.Request used to dispatch an
+// Extended CONNECT request (RFC 8441) to the handler: r.Method is "CONNECT"
+// and r.Proto is the bootstrapped protocol from the :protocol pseudo-header
+// (e.g. "websocket"), per RFC 8441 Section 4. It's rejected unless this
+// server has Extended CONNECT enabled.
+func newExtendedConnectRequest(settings *sessionSettings, authority, path, protocol string) (*http.Request, error) {
+	if !settings.enableExtendedConnect {
+		return nil, fmt.Errorf("h2quic: rejecting Extended CONNECT: this server did not advertise SETTINGS_ENABLE_CONNECT_PROTOCOL")
+	}
+	return &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Scheme: "https", Host: authority, Path: path},
+		Proto:      protocol,
+		ProtoMajor: 2,
+		Header:     http.Header{},
+		Host:       authority,
+	}, nil
+}
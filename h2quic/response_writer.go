@@ -0,0 +1,471 @@
+package h2quic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// responseWriter is the http.ResponseWriter (and http.Pusher) implementation
+// used for HTTP/2-over-QUIC responses. HEADERS frames for the response are
+// multiplexed onto the session's single header stream, tagged with the ID of
+// the data stream they belong to; the response body is written to that data
+// stream through the session's WriteScheduler.
+type responseWriter struct {
+	headerStream      quic.Stream
+	headerStreamMutex *sync.Mutex
+	dataStream        quic.Stream
+	dataStreamID      protocol.StreamID
+
+	settings *sessionSettings
+	session  quic.Session
+	handler  http.Handler
+
+	// request is the request this responseWriter is responding to. It is
+	// nil until setRequest is called, which the Server does before invoking
+	// the handler; it is used to decide whether to transparently compress
+	// the response.
+	request *http.Request
+
+	header        http.Header
+	status        int // status code passed to WriteHeader
+	headerWritten bool
+	bodyAllowed   bool
+
+	compressWriter *gzip.Writer
+
+	// headersSent is closed once WriteHeader has sent this response's
+	// HEADERS frame. A pushed responseWriter waits on its parent's
+	// headersSent before sending anything of its own, since PUSH_PROMISE
+	// must precede the parent's END_HEADERS but the promised response must
+	// follow it.
+	headersSent chan struct{}
+
+	// waitForParent, set on pushed responseWriters via waitForParentHeaders,
+	// is the parent's headersSent channel.
+	waitForParent <-chan struct{}
+
+	// trailer holds the trailer names that were pre-declared via the
+	// "Trailer" header before WriteHeader was called. Their values, along
+	// with any header set using the http.TrailerPrefix convention, are
+	// collected and sent once the handler returns, see Close.
+	trailer http.Header
+}
+
+func newResponseWriter(
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	dataStream quic.Stream,
+	dataStreamID protocol.StreamID,
+	settings *sessionSettings,
+	session quic.Session,
+	handler http.Handler,
+) *responseWriter {
+	settings.registerStream(dataStreamID, dataStream)
+	return &responseWriter{
+		headerStream:      headerStream,
+		headerStreamMutex: headerStreamMutex,
+		dataStream:        dataStream,
+		dataStreamID:      dataStreamID,
+		settings:          settings,
+		session:           session,
+		handler:           handler,
+		header:            http.Header{},
+		headersSent:       make(chan struct{}),
+	}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+// setRequest associates the request being served with this responseWriter.
+// The Server calls this before invoking the handler. If the request carries
+// "Expect: 100-continue", the request body is wrapped so that the first read
+// from it triggers an interim 100 Continue response, matching the net/http2
+// server's behavior.
+func (w *responseWriter) setRequest(req *http.Request) {
+	w.request = req
+	if req.Body != nil && req.Header.Get("Expect") == "100-continue" {
+		req.Body = &expectContinueReader{ReadCloser: req.Body, sendContinue: w.write100Continue}
+	}
+}
+
+// write100Continue sends an interim ":status: 100" HEADERS frame, without
+// END_STREAM, ahead of the real response headers.
+func (w *responseWriter) write100Continue() {
+	w.writeHeaderFrame(http.StatusContinue, nil, false)
+}
+
+// expectContinueReader sends its owning responseWriter's 100-continue
+// response the first time the request body is read from, as net/http2 does
+// for requests carrying "Expect: 100-continue".
+type expectContinueReader struct {
+	io.ReadCloser
+	sendContinue func()
+	sent         bool
+}
+
+func (r *expectContinueReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		r.sendContinue()
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// waitForParentHeaders makes this (pushed) responseWriter block its first
+// WriteHeader call until parentHeadersSent is closed, so the promised
+// response never races ahead of the parent response's own HEADERS frame.
+func (w *responseWriter) waitForParentHeaders(parentHeadersSent <-chan struct{}) {
+	w.waitForParent = parentHeadersSent
+}
+
+// WriteHeader sends an HTTP response header with the provided status code.
+// As with the net/http ResponseWriter, only the first call has any effect;
+// subsequent calls are no-ops.
+func (w *responseWriter) WriteHeader(status int) {
+	if w.headerWritten {
+		return
+	}
+	if w.waitForParent != nil {
+		<-w.waitForParent
+	}
+	w.headerWritten = true
+	w.status = status
+	w.bodyAllowed = bodyAllowedForStatus(status)
+	w.maybeEnableCompression()
+	w.promoteDeclaredTrailers()
+	w.writeHeaderFrame(status, w.header, false)
+	close(w.headersSent)
+}
+
+// maybeEnableCompression transparently gzip-compresses the response body
+// when the request advertised gzip support, compression is enabled on the
+// server, the handler hasn't already picked a Content-Encoding (a handler can
+// opt out entirely by setting Content-Encoding to "identity"), and the status
+// actually allows a body - compressing a 204 or 304 would mean writing a
+// gzip footer to a stream that's supposed to carry no body at all.
+func (w *responseWriter) maybeEnableCompression() {
+	if w.request == nil || w.settings.compressionLevel == gzip.NoCompression {
+		return
+	}
+	if !w.bodyAllowed {
+		return
+	}
+	if w.header.Get("Content-Encoding") != "" {
+		return
+	}
+	if !acceptsGzip(w.request) {
+		return
+	}
+	w.header.Set("Content-Encoding", "gzip")
+	w.header.Del("Content-Length")
+	w.header.Add("Vary", "Accept-Encoding")
+	compressWriter, err := gzip.NewWriterLevel(dataStreamSink{w}, w.settings.compressionLevel)
+	if err != nil {
+		compressWriter = gzip.NewWriter(dataStreamSink{w})
+	}
+	w.compressWriter = compressWriter
+}
+
+// dataStreamSink adapts responseWriter.writeData to an io.Writer, so that
+// the gzip.Writer used for compression goes through the WriteScheduler too,
+// rather than bypassing it straight to the data stream.
+type dataStreamSink struct{ w *responseWriter }
+
+func (s dataStreamSink) Write(p []byte) (int, error) { return s.w.writeData(p) }
+
+// acceptsGzip reports whether r's Accept-Encoding header lists gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(value) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHeaderFrame HPACK-encodes header (skipping the "Trailer" header and
+// anything using the http.TrailerPrefix convention) and sends it as a
+// HEADERS frame on the header stream, tagged with this response's data
+// stream ID.
+func (w *responseWriter) writeHeaderFrame(status int, header http.Header, endStream bool) error {
+	var buf bytes.Buffer
+	encoder := hpack.NewEncoder(&buf)
+	if status != 0 {
+		encoder.WriteField(hpack.HeaderField{Name: ":status", Value: fmt.Sprintf("%d", status)})
+	}
+	for name, values := range header {
+		// Check the Trailer/TrailerPrefix convention against the header's
+		// canonical casing before lowercasing: http.TrailerPrefix is
+		// "Trailer:" (capital T), which never matches an already-lowered
+		// name.
+		if strings.EqualFold(name, "trailer") || strings.HasPrefix(name, http.TrailerPrefix) {
+			continue
+		}
+		name = strings.ToLower(name)
+		for _, v := range values {
+			encoder.WriteField(hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+
+	w.headerStreamMutex.Lock()
+	defer w.headerStreamMutex.Unlock()
+	framer := http2.NewFramer(w.headerStream, nil)
+	return framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      uint32(w.dataStreamID),
+		EndHeaders:    true,
+		EndStream:     endStream,
+		BlockFragment: buf.Bytes(),
+	})
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.bodyAllowed {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.compressWriter != nil {
+		return w.compressWriter.Write(p)
+	}
+	return w.writeData(p)
+}
+
+// writeData hands p to the session's WriteScheduler, so it's written out in
+// priority order relative to whatever else the session has queued, rather
+// than going straight to the data stream.
+func (w *responseWriter) writeData(p []byte) (int, error) {
+	return w.settings.writeThroughScheduler(w.dataStreamID, p)
+}
+
+// Flush implements http.Flusher. It forces any buffered header state to be
+// written out, writing a 200 response if the handler hasn't called
+// WriteHeader yet, and flushes the gzip writer, if compression is active, so
+// that data written so far reaches the peer. Write itself only returns once
+// its data has been written to the QUIC stream through the WriteScheduler,
+// so there is nothing else to flush here.
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.compressWriter != nil {
+		w.compressWriter.Flush()
+	}
+}
+
+// Close is called once the handler has returned. It closes the gzip writer,
+// if compression is active, sends the collected trailers, if any, as a
+// second HEADERS frame with END_STREAM set, and then closes the data
+// stream.
+func (w *responseWriter) Close() error {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.compressWriter != nil {
+		if err := w.compressWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if trailers := w.collectTrailers(); len(trailers) > 0 {
+		if err := w.writeHeaderFrame(0, trailers, true); err != nil {
+			return err
+		}
+	}
+	defer w.settings.forgetStream(w.dataStreamID)
+	return w.dataStream.Close()
+}
+
+// promoteDeclaredTrailers reserves the trailer names announced via the
+// "Trailer" header, mirroring net/http, so that a handler may still set
+// their values after calling WriteHeader.
+func (w *responseWriter) promoteDeclaredTrailers() {
+	for _, line := range w.header["Trailer"] {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if w.trailer == nil {
+				w.trailer = http.Header{}
+			}
+			if _, ok := w.trailer[name]; !ok {
+				w.trailer[name] = nil
+			}
+		}
+	}
+}
+
+// collectTrailers gathers the values of the pre-declared trailers plus any
+// header set using the http.TrailerPrefix ("Trailer:") convention.
+func (w *responseWriter) collectTrailers() http.Header {
+	trailers := http.Header{}
+	for name := range w.trailer {
+		if v, ok := w.header[name]; ok {
+			trailers[name] = v
+		}
+	}
+	for name, values := range w.header {
+		if trimmed := strings.TrimPrefix(name, http.TrailerPrefix); trimmed != name {
+			trailers[http.CanonicalHeaderKey(trimmed)] = values
+		}
+	}
+	return trailers
+}
+
+// Stream returns the underlying QUIC stream for this response as an
+// io.ReadWriteCloser. It's for handlers dispatched via an Extended CONNECT
+// request (RFC 8441, r.Method == "CONNECT" with r.Proto set to the
+// bootstrapped protocol), which need to speak that protocol directly on the
+// stream rather than through the usual ResponseWriter/Request.Body pair -
+// WebSockets-over-QUIC being the motivating case. It returns an error unless
+// this response is answering such a request and this server has Extended
+// CONNECT enabled.
+func (w *responseWriter) Stream() (io.ReadWriteCloser, error) {
+	if w.request == nil || w.request.Method != http.MethodConnect || w.request.Proto == "" {
+		return nil, errors.New("h2quic: Stream is only available for Extended CONNECT requests")
+	}
+	if !w.settings.enableExtendedConnect {
+		return nil, errors.New("h2quic: this server did not advertise SETTINGS_ENABLE_CONNECT_PROTOCOL")
+	}
+	return w.dataStream, nil
+}
+
+// Push implements http.Pusher. The PUSH_PROMISE is sent, and the promised
+// stream reserved, before Push returns; the promised response itself is
+// produced by the handler on its own goroutine, so that multiple pushes (and
+// the parent response) proceed in parallel. The promised response's HEADERS
+// are held back until the parent's own HEADERS frame has been sent, and the
+// pushed handler is told to stop, via its Request's context, if the peer
+// cancels or refuses the pushed stream.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if !w.settings.enablePush {
+		return http.ErrNotSupported
+	}
+	if w.settings.pushTokens != nil {
+		select {
+		case w.settings.pushTokens <- struct{}{}:
+		default:
+			return http.ErrNotSupported
+		}
+	}
+	release := func() {
+		if w.settings.pushTokens != nil {
+			<-w.settings.pushTokens
+		}
+	}
+
+	if opts == nil {
+		opts = &http.PushOptions{}
+	}
+	method := opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	header := opts.Header
+	if header == nil {
+		header = http.Header{}
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		release()
+		return err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	if u.Host == "" {
+		u.Host = "www.example.com" // TODO: derive this from the original request
+	}
+
+	pushStream, err := w.session.OpenStream()
+	if err != nil {
+		release()
+		return err
+	}
+	if err := w.writePushPromise(pushStream.StreamID(), method, u, header); err != nil {
+		release()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(pushStream.Context())
+	req := (&http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     header,
+		Host:       u.Host,
+	}).WithContext(ctx)
+
+	pushWriter := newResponseWriter(w.headerStream, w.headerStreamMutex, pushStream, pushStream.StreamID(), w.settings, w.session, w.handler)
+	pushWriter.setRequest(req)
+	pushWriter.waitForParentHeaders(w.headersSent)
+
+	go func() {
+		defer release()
+		defer cancel()
+		defer pushWriter.Close()
+		w.handler.ServeHTTP(pushWriter, req)
+	}()
+	return nil
+}
+
+// writePushPromise HPACK-encodes the pushed request's headers and sends them
+// as a PUSH_PROMISE frame on the header stream, associated with the header
+// stream itself and promising promisedStreamID.
+func (w *responseWriter) writePushPromise(promisedStreamID protocol.StreamID, method string, u *url.URL, header http.Header) error {
+	var buf bytes.Buffer
+	encoder := hpack.NewEncoder(&buf)
+	encoder.WriteField(hpack.HeaderField{Name: ":method", Value: method})
+	encoder.WriteField(hpack.HeaderField{Name: ":scheme", Value: u.Scheme})
+	encoder.WriteField(hpack.HeaderField{Name: ":authority", Value: u.Host})
+	encoder.WriteField(hpack.HeaderField{Name: ":path", Value: u.RequestURI()})
+	for name, values := range header {
+		name = strings.ToLower(name)
+		for _, v := range values {
+			encoder.WriteField(hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+
+	w.headerStreamMutex.Lock()
+	defer w.headerStreamMutex.Unlock()
+	framer := http2.NewFramer(w.headerStream, nil)
+	return framer.WritePushPromise(http2.PushPromiseParam{
+		StreamID:      uint32(w.headerStream.StreamID()),
+		PromiseID:     uint32(promisedStreamID),
+		EndHeaders:    true,
+		BlockFragment: buf.Bytes(),
+	})
+}
+
+// bodyAllowedForStatus reports whether a response body is permitted for the
+// given status code (RFC 7230 Section 3.3). net/http has an identical
+// unexported helper that we can't import.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -2,11 +2,14 @@ package h2quic
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -101,6 +104,64 @@ var _ = Describe("Response Writer", func() {
 		return fields
 	}
 
+	// decodeAllHeaderFields decodes every HEADERS frame written to
+	// headerStream, in order, unlike decodeHeaderFields which only looks at
+	// the first one. It's used to inspect the trailer frame Close() sends
+	// after the main response HEADERS.
+	decodeAllHeaderFields := func() []map[string][]string {
+		var all []map[string][]string
+		h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+		for {
+			frame, err := h2framer.ReadFrame()
+			if err != nil {
+				break
+			}
+			hframe, ok := frame.(*http2.HeadersFrame)
+			if !ok {
+				continue
+			}
+			decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+			fields, err := decoder.DecodeFull(hframe.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			m := make(map[string][]string)
+			for _, p := range fields {
+				m[p.Name] = append(m[p.Name], p.Value)
+			}
+			all = append(all, m)
+		}
+		return all
+	}
+
+	It("sends pre-declared trailers as a second HEADERS frame after Close", func() {
+		// Close closes the data stream, which cancels its context.
+		dataStream.ctx, dataStream.ctxCancel = context.WithCancel(context.Background())
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Grpc-Status", "0")
+		Expect(w.Close()).To(Succeed())
+
+		frames := decodeAllHeaderFields()
+		Expect(frames).To(HaveLen(2))
+		Expect(frames[0]).ToNot(HaveKey("grpc-status"))
+		Expect(frames[1]).To(HaveKeyWithValue("grpc-status", []string{"0"}))
+	})
+
+	It("sends trailers set via the http.TrailerPrefix convention, without leaking them into the main HEADERS frame", func() {
+		dataStream.ctx, dataStream.ctxCancel = context.WithCancel(context.Background())
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		Expect(w.Close()).To(Succeed())
+
+		frames := decodeAllHeaderFields()
+		Expect(frames).To(HaveLen(2))
+		for name := range frames[0] {
+			if strings.HasPrefix(name, ":") { // pseudo-headers such as :status
+				continue
+			}
+			Expect(name).ToNot(ContainSubstring(":"))
+		}
+		Expect(frames[1]).To(HaveKeyWithValue("grpc-status", []string{"0"}))
+	})
+
 	It("writes status", func() {
 		w.WriteHeader(http.StatusTeapot)
 		fields := decodeHeaderFields()
@@ -128,6 +189,26 @@ var _ = Describe("Response Writer", func() {
 		Expect(cookies).To(ContainElement(cookie2))
 	})
 
+	It("sends a 100-continue response the first time the request body is read", func() {
+		req := &http.Request{
+			Header: http.Header{"Expect": []string{"100-continue"}},
+			Body:   ioutil.NopCloser(strings.NewReader("request body")),
+		}
+		w.setRequest(req)
+
+		buf := make([]byte, 4)
+		n, err := req.Body.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(BeNumerically(">", 0))
+
+		frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		hframe, ok := frame.(*http2.HeadersFrame)
+		Expect(ok).To(BeTrue())
+		Expect(hframe.StreamID).To(BeEquivalentTo(dataStream.id))
+		Expect(hframe.StreamEnded()).To(BeFalse())
+	})
+
 	It("writes data", func() {
 		n, err := w.Write([]byte("foobar"))
 		Expect(n).To(Equal(6))
@@ -167,16 +248,49 @@ var _ = Describe("Response Writer", func() {
 		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(0))
 	})
 
+	It("does not enable compression for a status that doesn't allow a body", func() {
+		dataStream.ctx, dataStream.ctxCancel = context.WithCancel(context.Background())
+		w.settings.compressionLevel = gzip.BestSpeed
+		w.setRequest(&http.Request{Header: http.Header{"Accept-Encoding": []string{"gzip"}}})
+		w.WriteHeader(http.StatusNoContent)
+		Expect(w.Header().Get("Content-Encoding")).To(BeEmpty())
+		Expect(w.Close()).To(Succeed())
+		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(0))
+	})
+
+	It("sends headers when Flush is called before any Write", func() {
+		w.Flush()
+		fields := decodeHeaderFields()
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+	})
+
+	It("flushes compressed data to the data stream without closing it", func() {
+		w.settings.compressionLevel = gzip.BestSpeed
+		w.setRequest(&http.Request{Header: http.Header{"Accept-Encoding": []string{"gzip"}}})
+		_, err := w.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		// gzip writes its 10-byte header at once but buffers the compressed
+		// body until flushed
+		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(10))
+
+		w.Flush()
+		Expect(dataStream.dataWritten.Len()).To(BeNumerically(">", 10))
+		Expect(dataStream.closed).To(BeFalse())
+	})
+
 	It("pushes", func() {
 		// test that we implement http.Pusher
 		var _ http.Pusher = &responseWriter{}
 		method := "GET"
 
 		fakePushData := "Pushed something"
+		pushed := make(chan struct{})
 
-		// HandlerFunc for pusher
+		// HandlerFunc for pusher. It runs on its own goroutine once Push
+		// returns, so completion is signaled through the pushed channel.
 		handlerFunc := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer GinkgoRecover()
+			defer close(pushed)
 			url := r.URL.String()
 			Expect(url).To(ContainSubstring(pushTarget))
 			Expect(r.Method).To(Equal(method))
@@ -212,9 +326,58 @@ var _ = Describe("Response Writer", func() {
 		Expect(fields[":authority"][0]).To(Equal("www.example.com")) // TODO get from pushTarget
 		Expect(fields[":path"][0]).To(Equal(pushTarget))             // TODO get from pushTarget
 
+		// The promised response must wait for the parent's own HEADERS.
+		w.WriteHeader(http.StatusOK)
+		Eventually(pushed).Should(BeClosed())
+
 		// Check new dataStream for pushed resource
 		fmt.Printf("Stream to push on: %q\n", pushStreamA.dataWritten.Bytes())
 		Expect(pushStreamA.dataWritten.Bytes()).To(Equal([]byte(fakePushData)))
+
+		// The pushed response's data stream must be closed once the pushed
+		// handler returns, same as an ordinary (non-pushed) response.
+		Eventually(func() bool { return pushStreamA.closed }).Should(BeTrue())
+	})
+
+	It("returns ErrNotSupported if push is disabled", func() {
+		settings := newSessionSettings()
+		settings.enablePush = false
+		localHandler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, dataStream.id, settings, session, localHandler)
+
+		Expect(w.Push(pushTarget, nil)).To(MatchError(http.ErrNotSupported))
+	})
+
+	It("returns ErrNotSupported once MaxConcurrentPushes is exhausted", func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		settings := newSessionSettings()
+		settings.pushTokens = make(chan struct{}, 1)
+		localHandler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release })
+		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, dataStream.id, settings, session, localHandler)
+		session.streamsToOpen = []quic.Stream{newMockStream(protocol.StreamID(6)), newMockStream(protocol.StreamID(8))}
+
+		Expect(w.Push(pushTarget, nil)).To(Succeed())
+		Expect(w.Push(pushTarget, nil)).To(MatchError(http.ErrNotSupported))
+	})
+
+	It("exposes the underlying stream for Extended CONNECT requests", func() {
+		settings := newSessionSettings()
+		settings.enableExtendedConnect = true
+		localHandler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, dataStream.id, settings, session, localHandler)
+		w.request = &http.Request{Method: http.MethodConnect, Proto: "websocket"}
+
+		stream, err := w.Stream()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stream).To(Equal(quic.Stream(dataStream)))
+	})
+
+	It("rejects Stream() if the peer didn't advertise Extended CONNECT", func() {
+		w.request = &http.Request{Method: http.MethodConnect, Proto: "websocket"}
+		_, err := w.Stream()
+		Expect(err).To(HaveOccurred())
 	})
 })
 
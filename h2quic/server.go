@@ -0,0 +1,150 @@
+package h2quic
+
+import (
+	"net/http"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Server wraps an http.Handler and serves it over HTTP/2 multiplexed on top
+// of QUIC.
+type Server struct {
+	// Handler is invoked for every request received on every session
+	// accepted by the server. If nil, http.DefaultServeMux is used.
+	Handler http.Handler
+
+	// CompressionLevel controls the gzip compression level applied to
+	// responses when a request's Accept-Encoding advertises gzip and the
+	// handler hasn't already set Content-Encoding. It accepts the same
+	// values as compress/gzip (gzip.DefaultCompression, gzip.BestSpeed,
+	// ...). The zero value, gzip.NoCompression, leaves the feature off, so
+	// compression is opt-in.
+	CompressionLevel int
+
+	// WriteScheduler, if non-nil, is used to order DATA writes across the
+	// streams of every session this server accepts, and is consulted for
+	// HTTP/2 PRIORITY frames received on the header stream. Defaults to the
+	// scheduler returned by quic.NewPriorityWriteScheduler, which honors RFC
+	// 7540 Section 5.3 dependency-tree priorities.
+	WriteScheduler quic.WriteScheduler
+
+	// MaxConcurrentPushes limits the number of server pushes that may be in
+	// flight at once for a single session. Once the limit is reached,
+	// further calls to Push return http.ErrNotSupported until a push
+	// completes. Zero means no limit.
+	MaxConcurrentPushes int
+}
+
+func (s *Server) handler() http.Handler {
+	if s.Handler != nil {
+		return s.Handler
+	}
+	return http.DefaultServeMux
+}
+
+// newSessionSettings builds the sessionSettings shared by every
+// responseWriter created for a session accepted by this server.
+func (s *Server) newSessionSettings() *sessionSettings {
+	settings := newSessionSettings()
+	settings.compressionLevel = s.CompressionLevel
+	if s.WriteScheduler != nil {
+		settings.scheduler = s.WriteScheduler
+	}
+	if s.MaxConcurrentPushes > 0 {
+		settings.pushTokens = make(chan struct{}, s.MaxConcurrentPushes)
+	}
+	return settings
+}
+
+// readHeaderStream reads HTTP/2 frames off a session's header stream for as
+// long as the session is alive, dispatching the ones h2quic interprets
+// itself: PRIORITY frames are forwarded to the session's WriteScheduler so it
+// can respect the peer's stream priorities, SETTINGS frames are applied to
+// settings, and HEADERS frames are checked for an Extended CONNECT request
+// (RFC 8441) to dispatch to the handler.
+func (s *Server) readHeaderStream(settings *sessionSettings, headerStreamMutex *sync.Mutex, headerStream quic.Stream, session quic.Session) error {
+	framer := http2.NewFramer(nil, headerStream)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+		switch f := frame.(type) {
+		case *http2.PriorityFrame:
+			s.handlePriorityFrame(settings, f)
+		case *http2.SettingsFrame:
+			s.handlePeerSettingsFrame(settings, f)
+		case *http2.MetaHeadersFrame:
+			s.handleExtendedConnectHeaders(settings, headerStreamMutex, headerStream, session, f)
+		}
+	}
+}
+
+// handlePriorityFrame forwards an HTTP/2 PRIORITY frame, read from a
+// session's header stream, to that session's WriteScheduler.
+func (s *Server) handlePriorityFrame(settings *sessionSettings, f *http2.PriorityFrame) {
+	settings.writeMu.Lock()
+	defer settings.writeMu.Unlock()
+	settings.scheduler.AdjustStream(protocol.StreamID(f.StreamID), quic.PriorityParam{
+		StreamDep: protocol.StreamID(f.StreamDep),
+		Exclusive: f.Exclusive,
+		Weight:    f.Weight,
+	})
+}
+
+// handlePeerSettingsFrame applies every value carried by an HTTP/2 SETTINGS
+// frame received on the header stream to settings.
+func (s *Server) handlePeerSettingsFrame(settings *sessionSettings, f *http2.SettingsFrame) {
+	f.ForeachSetting(func(setting http2.Setting) error {
+		s.handlePeerSetting(settings, setting)
+		return nil
+	})
+}
+
+// handleExtendedConnectHeaders inspects a HEADERS frame read off the header
+// stream for an Extended CONNECT request (RFC 8441: :method is CONNECT and a
+// :protocol pseudo-header is present) and, if found, dispatches it to the
+// handler on the data stream it named. Everything else - ordinary requests -
+// is outside the scope of this pruned header-stream reader.
+func (s *Server) handleExtendedConnectHeaders(settings *sessionSettings, headerStreamMutex *sync.Mutex, headerStream quic.Stream, session quic.Session, f *http2.MetaHeadersFrame) {
+	var method, authority, path, proto string
+	for _, hf := range f.Fields {
+		switch hf.Name {
+		case ":method":
+			method = hf.Value
+		case ":authority":
+			authority = hf.Value
+		case ":path":
+			path = hf.Value
+		case ":protocol":
+			proto = hf.Value
+		}
+	}
+	if method != http.MethodConnect || proto == "" {
+		return
+	}
+
+	streamID := protocol.StreamID(f.StreamID)
+	settings.writeMu.Lock()
+	dataStream, ok := settings.streams[streamID]
+	settings.writeMu.Unlock()
+	if !ok {
+		return
+	}
+
+	req, err := newExtendedConnectRequest(settings, authority, path, proto)
+	if err != nil {
+		return
+	}
+
+	w := newResponseWriter(headerStream, headerStreamMutex, dataStream, streamID, settings, session, s.handler())
+	w.setRequest(req)
+	s.handler().ServeHTTP(w, req)
+	w.Close()
+}
@@ -0,0 +1,108 @@
+package h2quic
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		server       *Server
+		settings     *sessionSettings
+		headerStream *mockStream
+		dataStream   *mockStream
+		session      *mockSession
+	)
+
+	BeforeEach(func() {
+		server = &Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+		settings = newSessionSettings()
+		headerStream = &mockStream{}
+		headerStream.id = protocol.StreamID(3)
+		dataStream = newMockStream(protocol.StreamID(5))
+		session = &mockSession{}
+	})
+
+	writeSettingsFrame := func(settingsToSend ...http2.Setting) []byte {
+		var buf bytes.Buffer
+		Expect(http2.NewFramer(&buf, nil).WriteSettings(settingsToSend...)).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("enables Extended CONNECT by default, matching initialSettings always advertising it", func() {
+		Expect(settings.enableExtendedConnect).To(BeTrue())
+	})
+
+	It("does not let the peer echoing SETTINGS_ENABLE_CONNECT_PROTOCOL back affect enableExtendedConnect", func() {
+		// RFC 8441 Section 3: it's the server that advertises this setting to
+		// the peer, not the other way around - a real client never sends it,
+		// but even if one did, it must not be able to flip the server's own
+		// enablement.
+		settings.enableExtendedConnect = false
+		frame, err := http2.NewFramer(nil, bytes.NewReader(writeSettingsFrame(http2.Setting{ID: extendedConnectProtocolSetting, Val: 1}))).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		server.handlePeerSettingsFrame(settings, frame.(*http2.SettingsFrame))
+		Expect(settings.enableExtendedConnect).To(BeFalse())
+	})
+
+	It("disables push when the peer sends SETTINGS_ENABLE_PUSH=0", func() {
+		Expect(settings.enablePush).To(BeTrue())
+		frame, err := http2.NewFramer(nil, bytes.NewReader(writeSettingsFrame(http2.Setting{ID: http2.SettingEnablePush, Val: 0}))).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		server.handlePeerSettingsFrame(settings, frame.(*http2.SettingsFrame))
+		Expect(settings.enablePush).To(BeFalse())
+	})
+
+	It("dispatches an Extended CONNECT request to the handler", func() {
+		var gotMethod, gotProto string
+		server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotMethod, gotProto = r.Method, r.Proto
+		})
+		settings.registerStream(dataStream.id, dataStream)
+
+		var buf bytes.Buffer
+		encoder := hpack.NewEncoder(&buf)
+		encoder.WriteField(hpack.HeaderField{Name: ":method", Value: "CONNECT"})
+		encoder.WriteField(hpack.HeaderField{Name: ":protocol", Value: "websocket"})
+		encoder.WriteField(hpack.HeaderField{Name: ":authority", Value: "example.com"})
+		encoder.WriteField(hpack.HeaderField{Name: ":path", Value: "/ws"})
+
+		decoder := hpack.NewDecoder(4096, nil)
+		fields, err := decoder.DecodeFull(buf.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		f := &http2.MetaHeadersFrame{
+			HeadersFrame: &http2.HeadersFrame{FrameHeader: http2.FrameHeader{StreamID: uint32(dataStream.id)}},
+			Fields:       fields,
+		}
+
+		server.handleExtendedConnectHeaders(settings, &sync.Mutex{}, headerStream, session, f)
+		Expect(gotMethod).To(Equal(http.MethodConnect))
+		Expect(gotProto).To(Equal("websocket"))
+	})
+
+	It("ignores an Extended CONNECT request if this server has Extended CONNECT disabled", func() {
+		called := false
+		server.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })
+		settings.enableExtendedConnect = false
+		settings.registerStream(dataStream.id, dataStream)
+
+		f := &http2.MetaHeadersFrame{
+			HeadersFrame: &http2.HeadersFrame{FrameHeader: http2.FrameHeader{StreamID: uint32(dataStream.id)}},
+			Fields: []hpack.HeaderField{
+				{Name: ":method", Value: "CONNECT"},
+				{Name: ":protocol", Value: "websocket"},
+			},
+		}
+
+		server.handleExtendedConnectHeaders(settings, &sync.Mutex{}, headerStream, session, f)
+		Expect(called).To(BeFalse())
+	})
+})
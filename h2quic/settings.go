@@ -0,0 +1,164 @@
+package h2quic
+
+import (
+	"compress/gzip"
+	"sync"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+// sessionSettings holds the HTTP/2-over-QUIC settings that were negotiated
+// for a session, plus the parts of the Server configuration that every
+// responseWriter belonging to that session needs to see. It is shared
+// between the responseWriters of all streams belonging to that session.
+type sessionSettings struct {
+	// enablePush defaults to true; it's cleared if the peer sends
+	// SETTINGS_ENABLE_PUSH=0, per RFC 7540 Section 8.2.1.
+	enablePush bool
+
+	// enableExtendedConnect reflects whether this server advertises
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL (RFC 8441 Section 3): it's the server
+	// that tells the peer Extended CONNECT is available, not the other way
+	// around, so this is never set from anything the peer sends. It defaults
+	// to true, matching initialSettings always advertising the setting.
+	enableExtendedConnect bool
+
+	// compressionLevel is copied from Server.CompressionLevel. It defaults
+	// to gzip.NoCompression, so automatic compression is opt-in.
+	compressionLevel int
+
+	// writeMu guards scheduler, streams and pending below: the scheduler
+	// implementations are plain data structures, not safe for concurrent use
+	// on their own, since multiple responseWriters (e.g. a pushed response's
+	// own goroutine, see chunk0-4) may submit writes concurrently within one
+	// session. It is never held across a call to a stream's blocking Write -
+	// that's writeLoop's job - so one stalled stream can't hold up everyone
+	// else's turn at the scheduler.
+	writeMu sync.Mutex
+
+	// scheduler orders DATA writes across the streams of this session. It
+	// is never nil: newSessionSettings defaults it to the scheduler returned
+	// by quic.NewPriorityWriteScheduler. Guarded by writeMu.
+	scheduler quic.WriteScheduler
+
+	// streams maps every data stream of this session to the quic.Stream
+	// responsible for it, so that writeLoop can write a popped frame to the
+	// right place regardless of which responseWriter submitted it. Guarded
+	// by writeMu.
+	streams map[protocol.StreamID]quic.Stream
+
+	// pending holds, per stream, one channel per write submitted to the
+	// scheduler that writeLoop hasn't handed to the stream yet, in the order
+	// they were submitted - the scheduler's per-stream queue is FIFO, so the
+	// first pending channel for a stream always belongs to the frame that's
+	// about to be written for it. Guarded by writeMu.
+	pending map[protocol.StreamID][]chan writeResult
+
+	// wake signals writeLoop that a write was just submitted to scheduler.
+	// It's buffered so a submission never blocks on writeLoop being busy.
+	wake chan struct{}
+
+	// pushTokens, if non-nil, is a buffered channel used as a semaphore
+	// bounding the number of pushes that may be in flight at once for this
+	// session. It is nil (no limit) unless Server.MaxConcurrentPushes > 0.
+	pushTokens chan struct{}
+}
+
+func newSessionSettings() *sessionSettings {
+	s := &sessionSettings{
+		enablePush:            true,
+		enableExtendedConnect: true,
+		compressionLevel:      gzip.NoCompression,
+		scheduler:             quic.NewPriorityWriteScheduler(),
+		streams:               make(map[protocol.StreamID]quic.Stream),
+		pending:               make(map[protocol.StreamID][]chan writeResult),
+		wake:                  make(chan struct{}, 1),
+	}
+	go s.writeLoop()
+	return s
+}
+
+// registerStream records which quic.Stream serves id, so that frames the
+// scheduler yields for id can be written out regardless of which
+// responseWriter is currently draining it.
+func (s *sessionSettings) registerStream(id protocol.StreamID, stream quic.Stream) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.streams[id] = stream
+}
+
+// forgetStream removes id's bookkeeping once its response is done.
+func (s *sessionSettings) forgetStream(id protocol.StreamID) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	delete(s.streams, id)
+	delete(s.pending, id)
+	s.scheduler.CloseStream(id)
+}
+
+// writeResult is how writeLoop reports a completed stream.Write back to the
+// goroutine that submitted it through writeThroughScheduler.
+type writeResult struct {
+	n   int
+	err error
+}
+
+// writeThroughScheduler submits data for id to the scheduler and waits for
+// writeLoop, the session's single writer, to hand it to id's stream in its
+// turn, honoring the relative priority of every stream sharing this session.
+func (s *sessionSettings) writeThroughScheduler(id protocol.StreamID, data []byte) (int, error) {
+	done := make(chan writeResult, 1)
+
+	s.writeMu.Lock()
+	s.scheduler.Push(quic.FrameWriteRequest{StreamID: id, Data: data})
+	s.pending[id] = append(s.pending[id], done)
+	s.writeMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	result := <-done
+	return result.n, result.err
+}
+
+// writeLoop is the session's arbiter: it owns the scheduler and decides,
+// under writeMu, which queued frame is picked next, but the stream.Write
+// call for that frame - which can block on that stream's flow control - runs
+// on its own goroutine rather than in this loop. That decouples picking the
+// next frame from waiting for the last one to land, so one stalled or
+// slow-reading stream can no longer freeze every other stream sharing this
+// session. Two frames for the same stream are never in flight at once: a
+// caller of writeThroughScheduler blocks on its own result, so it can't
+// submit that stream's next frame until this one is written.
+func (s *sessionSettings) writeLoop() {
+	for range s.wake {
+		for {
+			s.writeMu.Lock()
+			frame, ok := s.scheduler.Pop()
+			if !ok {
+				s.writeMu.Unlock()
+				break
+			}
+			stream := s.streams[frame.StreamID]
+			var done chan writeResult
+			if pending := s.pending[frame.StreamID]; len(pending) > 0 {
+				done = pending[0]
+				s.pending[frame.StreamID] = pending[1:]
+			}
+			s.writeMu.Unlock()
+
+			go func(stream quic.Stream, data []byte, done chan writeResult) {
+				var result writeResult
+				if stream != nil {
+					result.n, result.err = stream.Write(data)
+				}
+				if done != nil {
+					done <- result
+				}
+			}(stream, frame.Data, done)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package h2quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// blockingMockStream wraps a mockStream whose Write blocks until unblock is
+// closed, to simulate a stream stalled on flow control.
+type blockingMockStream struct {
+	*mockStream
+	unblock chan struct{}
+}
+
+func (s *blockingMockStream) Write(p []byte) (int, error) {
+	<-s.unblock
+	return s.mockStream.Write(p)
+}
+
+var _ = Describe("sessionSettings", func() {
+	It("doesn't let a stalled stream's Write hold up another stream's Write", func() {
+		settings := newSessionSettings()
+
+		stalled := &blockingMockStream{mockStream: newMockStream(protocol.StreamID(5)), unblock: make(chan struct{})}
+		settings.registerStream(stalled.id, stalled)
+
+		live := newMockStream(protocol.StreamID(7))
+		settings.registerStream(live.id, live)
+
+		stalledDone := make(chan struct{})
+		go func() {
+			defer close(stalledDone)
+			defer GinkgoRecover()
+			_, err := settings.writeThroughScheduler(stalled.id, []byte("stalled"))
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		Consistently(stalledDone, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		n, err := settings.writeThroughScheduler(live.id, []byte("live"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(len("live")))
+		Expect(live.dataWritten.Bytes()).To(Equal([]byte("live")))
+
+		close(stalled.unblock)
+		Eventually(stalledDone).Should(BeClosed())
+		Expect(stalled.dataWritten.Bytes()).To(Equal([]byte("stalled")))
+	})
+})
@@ -0,0 +1,45 @@
+package quic
+
+import "github.com/lucas-clemente/quic-go/internal/protocol"
+
+// WriteScheduler decides in what order queued stream data is written to the
+// connection. It is the QUIC analogue of the write scheduler x/net/http2
+// uses internally, and lets h2quic.Server honor HTTP/2 PRIORITY frames
+// received on the header stream.
+type WriteScheduler interface {
+	// Push queues frame for writing. The frame's StreamID determines which
+	// stream's priority state it is scheduled under.
+	Push(frame FrameWriteRequest)
+
+	// Pop dequeues the next frame to write, in priority order. It reports
+	// false if there is nothing queued.
+	Pop() (FrameWriteRequest, bool)
+
+	// AdjustStream updates the priority of id as conveyed by an HTTP/2
+	// PRIORITY frame (RFC 7540 Section 5.3), re-parenting its dependency
+	// tree position. A scheduler that tracks priority starts tracking id if
+	// it is not yet known, so a PRIORITY frame may precede any data for id.
+	AdjustStream(id protocol.StreamID, priority PriorityParam)
+
+	// CloseStream discards any frames still queued for id and forgets its
+	// priority state.
+	CloseStream(id protocol.StreamID)
+}
+
+// FrameWriteRequest is a chunk of stream data queued with a WriteScheduler.
+type FrameWriteRequest struct {
+	StreamID protocol.StreamID
+	Data     []byte
+}
+
+// PriorityParam mirrors the parameters carried by an HTTP/2 PRIORITY frame.
+type PriorityParam struct {
+	// StreamDep is the stream ID this stream depends on.
+	StreamDep protocol.StreamID
+	// Exclusive, if true, makes this stream StreamDep's sole child,
+	// re-parenting StreamDep's other children underneath it.
+	Exclusive bool
+	// Weight is the zero-indexed weight as carried on the wire, in [0, 255];
+	// the effective weight is Weight+1, in [1, 256].
+	Weight uint8
+}
@@ -0,0 +1,186 @@
+package quic
+
+import "github.com/lucas-clemente/quic-go/internal/protocol"
+
+// streamPrioNode is one node of a PriorityWriteScheduler's dependency tree.
+type streamPrioNode struct {
+	id       protocol.StreamID
+	weight   int // [1, 256]
+	parent   *streamPrioNode
+	children []*streamPrioNode
+	queue    [][]byte
+
+	// currentWeight is this node's running tally in the smooth weighted
+	// round-robin used to pick among siblings, see priorityWriteScheduler.pick.
+	currentWeight int
+}
+
+// hasQueuedData reports whether n or any of its descendants have a frame
+// waiting to be written.
+func (n *streamPrioNode) hasQueuedData() bool {
+	if len(n.queue) > 0 {
+		return true
+	}
+	for _, c := range n.children {
+		if c.hasQueuedData() {
+			return true
+		}
+	}
+	return false
+}
+
+func newStreamPrioNode(id protocol.StreamID) *streamPrioNode {
+	return &streamPrioNode{id: id, weight: 16}
+}
+
+func (n *streamPrioNode) addChild(c *streamPrioNode) {
+	c.parent = n
+	n.children = append(n.children, c)
+}
+
+func (n *streamPrioNode) removeChild(c *streamPrioNode) {
+	for i, ch := range n.children {
+		if ch == c {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// priorityWriteScheduler implements WriteScheduler following RFC 7540's
+// dependency-tree priority scheme: streams are nodes in a tree, and siblings
+// share their parent's bandwidth in proportion to their weights (1-256).
+type priorityWriteScheduler struct {
+	root  *streamPrioNode
+	nodes map[protocol.StreamID]*streamPrioNode
+}
+
+// NewPriorityWriteScheduler returns a WriteScheduler that schedules DATA
+// strictly according to the RFC 7540 Section 5.3 dependency tree.
+func NewPriorityWriteScheduler() WriteScheduler {
+	return &priorityWriteScheduler{
+		root:  &streamPrioNode{},
+		nodes: make(map[protocol.StreamID]*streamPrioNode),
+	}
+}
+
+func (ws *priorityWriteScheduler) nodeFor(id protocol.StreamID) *streamPrioNode {
+	n, ok := ws.nodes[id]
+	if !ok {
+		n = newStreamPrioNode(id)
+		ws.root.addChild(n)
+		ws.nodes[id] = n
+	}
+	return n
+}
+
+func (ws *priorityWriteScheduler) Push(frame FrameWriteRequest) {
+	n := ws.nodeFor(frame.StreamID)
+	n.queue = append(n.queue, frame.Data)
+}
+
+// Pop walks the dependency tree depth-first, using weighted round-robin
+// among siblings, and returns the first queued frame it finds.
+func (ws *priorityWriteScheduler) Pop() (FrameWriteRequest, bool) {
+	n := ws.pick(ws.root, make(map[*streamPrioNode]bool))
+	if n == nil {
+		return FrameWriteRequest{}, false
+	}
+	data := n.queue[0]
+	n.queue = n.queue[1:]
+	return FrameWriteRequest{StreamID: n.id, Data: data}, true
+}
+
+// pick selects which of n's children to serve next using smooth weighted
+// round-robin (as used by, e.g., nginx upstream balancing): every ready
+// child's currentWeight is bumped by its own weight, the child with the
+// largest currentWeight is chosen and debited by the sum of all weights
+// considered. Averaged over many picks, each child is chosen in proportion
+// to its weight, which is RFC 7540 Section 5.3's bandwidth-sharing rule.
+func (ws *priorityWriteScheduler) pick(n *streamPrioNode, visited map[*streamPrioNode]bool) *streamPrioNode {
+	if visited[n] || len(n.children) == 0 {
+		return nil
+	}
+	visited[n] = true
+
+	var best *streamPrioNode
+	totalWeight := 0
+	for _, c := range n.children {
+		if !c.hasQueuedData() {
+			continue
+		}
+		c.currentWeight += int(c.weight)
+		totalWeight += int(c.weight)
+		if best == nil || c.currentWeight > best.currentWeight {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.currentWeight -= totalWeight
+	if len(best.queue) > 0 {
+		return best
+	}
+	return ws.pick(best, visited)
+}
+
+// isDescendant reports whether candidate is n itself or appears somewhere in
+// n's subtree.
+func isDescendant(n, candidate *streamPrioNode) bool {
+	if n == candidate {
+		return true
+	}
+	for _, c := range n.children {
+		if isDescendant(c, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ws *priorityWriteScheduler) AdjustStream(id protocol.StreamID, priority PriorityParam) {
+	n := ws.nodeFor(id)
+	n.weight = int(priority.Weight) + 1
+
+	dep := ws.nodeFor(priority.StreamDep)
+	if dep == n {
+		return
+	}
+
+	// RFC 7540 Section 5.3.3: if dep is currently a descendant of n, plainly
+	// reparenting n under dep would strand the pair in a cycle, disconnected
+	// from the rest of the tree. Move dep to n's old position first, so it
+	// keeps a place in the tree once n is no longer there.
+	if n.parent != nil && isDescendant(n, dep) {
+		oldParent := n.parent
+		dep.parent.removeChild(dep)
+		oldParent.removeChild(n)
+		oldParent.addChild(dep)
+	} else if n.parent != nil {
+		n.parent.removeChild(n)
+	}
+
+	if priority.Exclusive {
+		children := dep.children
+		dep.children = nil
+		for _, c := range children {
+			n.addChild(c)
+		}
+	}
+	dep.addChild(n)
+}
+
+func (ws *priorityWriteScheduler) CloseStream(id protocol.StreamID) {
+	n, ok := ws.nodes[id]
+	if !ok {
+		return
+	}
+	if n.parent != nil {
+		n.parent.removeChild(n)
+		for _, c := range n.children {
+			n.parent.addChild(c)
+		}
+	}
+	delete(ws.nodes, id)
+}
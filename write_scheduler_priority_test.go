@@ -0,0 +1,69 @@
+package quic
+
+import (
+	"testing"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+// TestPriorityWriteSchedulerHonorsWeight checks that Pop serves siblings of
+// equal priority depth in proportion to their weight, not in plain
+// round-robin regardless of it.
+func TestPriorityWriteSchedulerHonorsWeight(t *testing.T) {
+	ws := NewPriorityWriteScheduler()
+	light, heavy := protocol.StreamID(1), protocol.StreamID(3)
+	// Weight is zero-indexed: these are effective weights 1 and 255.
+	ws.AdjustStream(light, PriorityParam{Weight: 0})
+	ws.AdjustStream(heavy, PriorityParam{Weight: 254})
+
+	const frames = 256
+	for i := 0; i < frames; i++ {
+		ws.Push(FrameWriteRequest{StreamID: light, Data: []byte("l")})
+		ws.Push(FrameWriteRequest{StreamID: heavy, Data: []byte("h")})
+	}
+
+	// Only look at the first frames pops: draining both queues completely
+	// would pop every frame of both streams, whatever their weights.
+	counts := map[protocol.StreamID]int{}
+	for i := 0; i < frames; i++ {
+		frame, ok := ws.Pop()
+		if !ok {
+			break
+		}
+		counts[frame.StreamID]++
+	}
+
+	if counts[light] == counts[heavy] {
+		t.Fatalf("expected weight 255 to get far more turns than weight 1, got %d vs %d", counts[heavy], counts[light])
+	}
+	if counts[heavy] <= counts[light]*10 {
+		t.Fatalf("expected heavy stream to dominate roughly 255:1, got heavy=%d light=%d", counts[heavy], counts[light])
+	}
+}
+
+// TestPriorityWriteSchedulerAvoidsReparentCycle checks that reprioritizing a
+// stream onto one of its own dependents, per RFC 7540 Section 5.3.3, keeps
+// both streams reachable from the root instead of stranding them in a cycle
+// disconnected from the rest of the tree.
+func TestPriorityWriteSchedulerAvoidsReparentCycle(t *testing.T) {
+	ws := NewPriorityWriteScheduler()
+	a, b := protocol.StreamID(3), protocol.StreamID(5)
+	ws.AdjustStream(b, PriorityParam{StreamDep: a})
+	ws.AdjustStream(a, PriorityParam{StreamDep: b})
+
+	ws.Push(FrameWriteRequest{StreamID: a, Data: []byte("a")})
+	ws.Push(FrameWriteRequest{StreamID: b, Data: []byte("b")})
+
+	counts := map[protocol.StreamID]int{}
+	for {
+		frame, ok := ws.Pop()
+		if !ok {
+			break
+		}
+		counts[frame.StreamID]++
+	}
+
+	if counts[a] != 1 || counts[b] != 1 {
+		t.Fatalf("expected both streams to be reachable and popped once each, got a=%d b=%d", counts[a], counts[b])
+	}
+}
@@ -0,0 +1,58 @@
+package quic
+
+import "github.com/lucas-clemente/quic-go/internal/protocol"
+
+// randomWriteScheduler implements WriteScheduler by ignoring priority
+// entirely and popping frames in the order their streams were first seen,
+// round-robin across streams. It's useful in tests, where deterministic
+// output that doesn't depend on priority-tree bookkeeping is easier to
+// assert on.
+type randomWriteScheduler struct {
+	order []protocol.StreamID
+	queue map[protocol.StreamID][][]byte
+	next  int
+}
+
+// NewRandomWriteScheduler returns a WriteScheduler with no priority
+// handling, suitable for tests.
+func NewRandomWriteScheduler() WriteScheduler {
+	return &randomWriteScheduler{
+		queue: make(map[protocol.StreamID][][]byte),
+	}
+}
+
+func (ws *randomWriteScheduler) Push(frame FrameWriteRequest) {
+	if _, ok := ws.queue[frame.StreamID]; !ok {
+		ws.order = append(ws.order, frame.StreamID)
+	}
+	ws.queue[frame.StreamID] = append(ws.queue[frame.StreamID], frame.Data)
+}
+
+func (ws *randomWriteScheduler) Pop() (FrameWriteRequest, bool) {
+	for i := 0; i < len(ws.order); i++ {
+		idx := (ws.next + i) % len(ws.order)
+		id := ws.order[idx]
+		q := ws.queue[id]
+		if len(q) == 0 {
+			continue
+		}
+		ws.queue[id] = q[1:]
+		ws.next = (idx + 1) % len(ws.order)
+		return FrameWriteRequest{StreamID: id, Data: q[0]}, true
+	}
+	return FrameWriteRequest{}, false
+}
+
+func (ws *randomWriteScheduler) AdjustStream(protocol.StreamID, PriorityParam) {
+	// randomWriteScheduler ignores priority entirely.
+}
+
+func (ws *randomWriteScheduler) CloseStream(id protocol.StreamID) {
+	delete(ws.queue, id)
+	for i, sid := range ws.order {
+		if sid == id {
+			ws.order = append(ws.order[:i], ws.order[i+1:]...)
+			return
+		}
+	}
+}